@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/juju/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// batchConfig is the shape of the YAML file passed to `speedlingo batch`.
+// Command and Workers apply to every repo unless overridden per-repo.
+type batchConfig struct {
+	Command string      `yaml:"command"`
+	Workers int         `yaml:"workers"`
+	Repos   []batchRepo `yaml:"repos"`
+}
+
+// batchRepo is one target repository in a batch run, with optional per-repo
+// overrides of the batch-wide defaults.
+type batchRepo struct {
+	Owner   string        `yaml:"owner"`
+	Repo    string        `yaml:"repo"`
+	Command string        `yaml:"command"`
+	Branch  string        `yaml:"branch"`
+	Tenets  []tenetConfig `yaml:"tenets"`
+	Skip    bool          `yaml:"skip"`
+}
+
+// repoResult is one entry of the JSON summary written after a batch run.
+type repoResult struct {
+	Owner  string `json:"owner"`
+	Repo   string `json:"repo"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	statusSuccess = "success"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+
+	defaultBatchWorkers = 4
+	batchRetryAttempts  = 3
+	batchRetryDelay     = 5 * time.Second
+
+	batchSummaryFileName = "batch-summary.json"
+)
+
+// batchJob pairs a repo with its position in batchConfig.Repos so results can
+// be written back in the original order despite out-of-order completion.
+type batchJob struct {
+	index int
+	repo  batchRepo
+}
+
+// runBatch reads batchFile and processes every listed repo with a pool of
+// workers, writing a JSON summary of per-repo outcomes to reviewResultsDir.
+func runBatch(ctx context.Context, client *github.Client, batchFile string) error {
+	str, err := ioutil.ReadFile(batchFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var bc batchConfig
+	if err = yaml.UnmarshalStrict(str, &bc); err != nil {
+		return errors.Trace(err)
+	}
+
+	workers := bc.Workers
+	if workers <= 0 {
+		workers = defaultBatchWorkers
+	}
+
+	jobs := make(chan batchJob)
+	results := make([]repoResult, len(bc.Repos))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results[job.index] = runBatchRepo(ctx, client, bc.Command, job.repo)
+			}
+		}()
+	}
+
+	for i, repo := range bc.Repos {
+		jobs <- batchJob{index: i, repo: repo}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return writeBatchSummary(results)
+}
+
+// runBatchRepo processes a single batch entry, retrying transient failures,
+// and always returns a result rather than an error so one repo's failure
+// doesn't abort the rest of the batch.
+func runBatchRepo(ctx context.Context, client *github.Client, defaultCommand string, repo batchRepo) repoResult {
+	result := repoResult{Owner: repo.Owner, Repo: repo.Repo}
+
+	if repo.Skip {
+		result.Status = statusSkipped
+		return result
+	}
+
+	command := repo.Command
+	if command == "" {
+		command = defaultCommand
+	}
+
+	branch := repo.Branch
+	if branch == "" {
+		branch = branchName
+	}
+
+	tenets := repo.Tenets
+	if len(tenets) == 0 {
+		tenets = conf.Tenets
+	}
+	tenetsYAML, err := renderTenetsYAML(tenets)
+	if err != nil {
+		result.Status = statusFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	logger := log.New(os.Stdout, fmt.Sprintf("[%s/%s] ", repo.Owner, repo.Repo), log.LstdFlags)
+
+	err = withRetry(batchRetryAttempts, batchRetryDelay, func() error {
+		return processRepo(ctx, client, repo.Owner, repo.Repo, command, branch, tenetsYAML, logger)
+	})
+	if err != nil {
+		logger.Println("Failed:", err)
+		result.Status = statusFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Status = statusSuccess
+	return result
+}
+
+func writeBatchSummary(results []repoResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	filename := filepath.Join(reviewResultsDir, batchSummaryFileName)
+	if err = ioutil.WriteFile(filename, data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+
+	fmt.Println("Wrote batch summary to", filename)
+
+	return nil
+}
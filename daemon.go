@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/juju/errors"
+	git "gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// daemonConfig is the shape of the YAML file passed to `speedlingo daemon`.
+type daemonConfig struct {
+	PollInterval string       `yaml:"pollinterval"`
+	StatusAddr   string       `yaml:"statusaddr"`
+	Repos        []daemonRepo `yaml:"repos"`
+}
+
+// daemonRepo is one upstream repository tracked by the daemon.
+type daemonRepo struct {
+	Owner  string        `yaml:"owner"`
+	Repo   string        `yaml:"repo"`
+	Tenets []tenetConfig `yaml:"tenets"`
+}
+
+const (
+	defaultPollInterval = 10 * time.Minute
+	defaultStatusAddr   = ":8089"
+	daemonStateFileName = "daemon-state.json"
+)
+
+// trackedRepoState is the daemon's view of one tracked repo, persisted to
+// disk so a restart doesn't re-open PRs for commits already seen.
+type trackedRepoState struct {
+	Owner        string    `json:"owner"`
+	Repo         string    `json:"repo"`
+	LastSHA      string    `json:"lastSHA"`
+	LastPollTime time.Time `json:"lastPollTime"`
+	LastPRURL    string    `json:"lastPRURL,omitempty"`
+	LastError    string    `json:"lastError,omitempty"`
+}
+
+// daemon polls a set of upstream repos on an interval and re-runs the
+// rewrite pipeline whenever a repo's default branch advances, exposing its
+// state over HTTP so it can be run as a long-lived service.
+type daemon struct {
+	client    *github.Client
+	statePath string
+
+	mu    sync.Mutex
+	state map[string]*trackedRepoState
+}
+
+func repoKey(owner, repo string) string {
+	return owner + "/" + repo
+}
+
+// runDaemon loads daemonFile and starts polling its repos until ctx is
+// cancelled, serving a JSON status endpoint on the configured address.
+func runDaemon(ctx context.Context, client *github.Client, daemonFile string) error {
+	str, err := ioutil.ReadFile(daemonFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var dc daemonConfig
+	if err = yaml.UnmarshalStrict(str, &dc); err != nil {
+		return errors.Trace(err)
+	}
+
+	interval := defaultPollInterval
+	if dc.PollInterval != "" {
+		interval, err = time.ParseDuration(dc.PollInterval)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	statusAddr := dc.StatusAddr
+	if statusAddr == "" {
+		statusAddr = defaultStatusAddr
+	}
+
+	d := &daemon{
+		client:    client,
+		statePath: filepath.Join(reviewResultsDir, daemonStateFileName),
+		state:     map[string]*trackedRepoState{},
+	}
+	if err = d.loadState(); err != nil {
+		return errors.Trace(err)
+	}
+	for _, r := range dc.Repos {
+		key := repoKey(r.Owner, r.Repo)
+		if _, ok := d.state[key]; !ok {
+			d.state[key] = &trackedRepoState{Owner: r.Owner, Repo: r.Repo}
+		}
+	}
+
+	go func() {
+		http.HandleFunc("/status", d.statusHandler)
+		log.Println("Daemon status endpoint listening on", statusAddr)
+		log.Println(http.ListenAndServe(statusAddr, nil))
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, r := range dc.Repos {
+			d.pollRepo(ctx, r)
+		}
+		if err = d.saveState(); err != nil {
+			log.Println("Failed to persist daemon state:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollRepo checks whether owner/repo's default branch has advanced since the
+// last poll, and if so, refreshes the fork and re-runs the rewrite pipeline
+// against it.
+func (d *daemon) pollRepo(ctx context.Context, repo daemonRepo) {
+	key := repoKey(repo.Owner, repo.Repo)
+	logger := log.New(os.Stdout, fmt.Sprintf("[%s] ", key), log.LstdFlags)
+
+	d.mu.Lock()
+	st := *d.state[key]
+	d.mu.Unlock()
+
+	// Write the (possibly mutated) local copy back under lock once, on every
+	// return path, so statusHandler never observes a partially-updated state.
+	defer func() {
+		d.mu.Lock()
+		*d.state[key] = st
+		d.mu.Unlock()
+	}()
+
+	st.LastPollTime = time.Now()
+
+	upstream, _, err := d.client.Repositories.Get(ctx, repo.Owner, repo.Repo)
+	if err != nil {
+		st.LastError = err.Error()
+		logger.Println("Failed to fetch upstream repo:", err)
+		return
+	}
+
+	branch, _, err := d.client.Repositories.GetBranch(ctx, repo.Owner, repo.Repo, *upstream.DefaultBranch)
+	if err != nil {
+		st.LastError = err.Error()
+		logger.Println("Failed to fetch upstream default branch:", err)
+		return
+	}
+
+	sha := *branch.Commit.SHA
+	if sha == st.LastSHA {
+		return
+	}
+
+	logger.Println("Upstream advanced to", sha)
+
+	tenets := repo.Tenets
+	if len(tenets) == 0 {
+		tenets = conf.Tenets
+	}
+	tenetsYAML, err := renderTenetsYAML(tenets)
+	if err != nil {
+		st.LastError = err.Error()
+		logger.Println("Failed to render tenets:", err)
+		return
+	}
+
+	shortSHA := sha
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+	rewriteBranch := fmt.Sprintf("rewrite-%s", shortSHA)
+
+	var prURL string
+	err = withRetry(batchRetryAttempts, batchRetryDelay, func() error {
+		url, err := d.refreshAndRewrite(ctx, repo.Owner, repo.Repo, *upstream.DefaultBranch, rewriteBranch, tenetsYAML, logger)
+		prURL = url
+		return err
+	})
+	if err != nil {
+		st.LastError = err.Error()
+		logger.Println("Failed:", err)
+		return
+	}
+
+	st.LastSHA = sha
+	st.LastError = ""
+	st.LastPRURL = prURL
+}
+
+// refreshAndRewrite forks owner/repo (if needed), fast-forwards the fork's
+// default branch to match upstream, then runs the rewrite pipeline on a
+// fresh rewrite-<shortsha> branch and opens a pull request, returning its URL.
+func (d *daemon) refreshAndRewrite(ctx context.Context, owner, repo, defaultBranch, rewriteBranch, tenetsYAML string, logger *log.Logger) (string, error) {
+	rf, _, err := d.client.Repositories.CreateFork(ctx, owner, repo, nil)
+	if err != nil && !strings.Contains(err.Error(), "job scheduled on GitHub side; try again later") {
+		return "", errors.Trace(err)
+	}
+
+	timeout := time.Now().Add(time.Minute * 5)
+	for {
+		if time.Now().After(timeout) {
+			return "", errors.Trace(err)
+		}
+		rf, _, err = d.client.Repositories.Get(ctx, conf.Username, repo)
+		if err != nil {
+			time.Sleep(time.Second * 2)
+			continue
+		}
+		break
+	}
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer os.RemoveAll(dir)
+
+	auth, err := buildAuth(conf, conf.Token)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	r, err := git.PlainClone(dir, false, &git.CloneOptions{
+		URL:      cloneURL(conf, rf),
+		Auth:     auth,
+		Progress: os.Stdout,
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	upstreamURL := fmt.Sprintf("https://github.com/%s/%s.git", owner, repo)
+	if err = refreshForkBranch(r, upstreamURL, defaultBranch, auth); err != nil {
+		return "", errors.Trace(err)
+	}
+
+	// Each poll cycle rewrites onto its own rewrite-<shortsha> branch, so
+	// close out any PR opened from a previous cycle before opening the new
+	// one - otherwise every advancing upstream commit leaves behind a PR
+	// that never gets closed.
+	if err = closeSupersededPullRequests(ctx, d.client, owner, repo, rewriteBranch, logger); err != nil {
+		return "", errors.Trace(err)
+	}
+
+	cmd := exec.Command("lingo", "run", "rewrite", "--debug", "--keep-all")
+	cmd.Dir = dir
+	return handleRewrite(ctx, d.client, owner, repo, dir, conf.Token, r, cmd, rewriteBranch, tenetsYAML, logger)
+}
+
+// closeSupersededPullRequests closes any still-open pull requests previously
+// opened by this tool from an earlier rewrite-<shortsha> branch, so only the
+// PR for keepBranch is left open.
+func closeSupersededPullRequests(ctx context.Context, client *github.Client, owner, repo, keepBranch string, logger *log.Logger) error {
+	prs, _, err := client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{State: "open"})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, pr := range prs {
+		if pr.Head == nil || pr.Head.Ref == nil || pr.Head.User == nil || pr.Head.User.Login == nil {
+			continue
+		}
+		if *pr.Head.User.Login != conf.Username || !strings.HasPrefix(*pr.Head.Ref, "rewrite-") || *pr.Head.Ref == keepBranch {
+			continue
+		}
+
+		closed := "closed"
+		if _, _, err := client.PullRequests.Edit(ctx, owner, repo, *pr.Number, &github.PullRequest{State: &closed}); err != nil {
+			return errors.Trace(err)
+		}
+		logger.Println("Closed superseded pull request", *pr.HTMLURL)
+	}
+
+	return nil
+}
+
+// refreshForkBranch fast-forwards defaultBranch in the cloned fork r to
+// match the same branch on the upstream remote, then pushes it back to the
+// fork, so the rewrite pipeline always starts from the latest upstream
+// commit.
+func refreshForkBranch(r *git.Repository, upstreamURL, defaultBranch string, auth transport.AuthMethod) error {
+	_, err := r.CreateRemote(&gitconfig.RemoteConfig{Name: "upstream", URLs: []string{upstreamURL}})
+	if err != nil && err != git.ErrRemoteExists {
+		return errors.Trace(err)
+	}
+
+	if err = r.Fetch(&git.FetchOptions{RemoteName: "upstream", Progress: os.Stdout}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Trace(err)
+	}
+
+	upstreamRef, err := r.Reference(plumbing.NewRemoteReferenceName("upstream", defaultBranch), true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	localBranch := plumbing.NewBranchReferenceName(defaultBranch)
+	if err = r.Storer.SetReference(plumbing.NewHashReference(localBranch, upstreamRef.Hash())); err != nil {
+		return errors.Trace(err)
+	}
+
+	worktree, err := r.Worktree()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = worktree.Checkout(&git.CheckoutOptions{Branch: localBranch, Force: true}); err != nil {
+		return errors.Trace(err)
+	}
+
+	refSpec := gitconfig.RefSpec(fmt.Sprintf("%s:%s", localBranch, localBranch))
+	err = r.Push(&git.PushOptions{RemoteName: "origin", RefSpecs: []gitconfig.RefSpec{refSpec}, Auth: auth})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Trace(err)
+	}
+
+	return nil
+}
+
+func (d *daemon) statusHandler(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.state)
+}
+
+func (d *daemon) loadState() error {
+	str, err := ioutil.ReadFile(d.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return errors.Trace(json.Unmarshal(str, &d.state))
+}
+
+func (d *daemon) saveState() error {
+	d.mu.Lock()
+	data, err := json.MarshalIndent(d.state, "", "  ")
+	d.mu.Unlock()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(ioutil.WriteFile(d.statePath, data, 0644))
+}
@@ -15,25 +15,55 @@ import (
 	"github.com/juju/errors"
 	"golang.org/x/oauth2"
 	git "gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
 	yaml "gopkg.in/yaml.v2"
 )
 
 type config struct {
-	Username string `yaml:"username"`
-	Email    string `yaml:"email"`
-	Token    string `yaml:"token"`
+	Username string        `yaml:"username"`
+	Email    string        `yaml:"email"`
+	Token    string        `yaml:"token"`
+	PRTitle  string        `yaml:"prtitle"`
+	PRBody   string        `yaml:"prbody"`
+	Auth     authConfig    `yaml:"auth"`
+	Tenets   []tenetConfig `yaml:"tenets"`
 }
 
-const yamlDataReview = `tenets:
-  - import: codelingo/code-review-comments
-  - import: codelingo/effective-go
-`
-const yamlDataRewrite = `tenets:
-  - import: codelingo/effective-go/comment-first-word-as-subject
-`
+// tenetConfig is one entry of a Tenets list: a CodeLingo tenet import path
+// plus any tenet-specific options, written out verbatim into codelingo.yaml.
+type tenetConfig struct {
+	Import  string                 `yaml:"import"`
+	Options map[string]interface{} `yaml:"options,omitempty"`
+}
+
+// tenetsFile mirrors the shape of codelingo.yaml, so a []tenetConfig can be
+// marshalled straight into the file lingo expects.
+type tenetsFile struct {
+	Tenets []tenetConfig `yaml:"tenets"`
+}
+
+// authConfig selects and configures the transport used to clone and push.
+// Method is either "https" (the default, using Token above) or "ssh".
+type authConfig struct {
+	Method           string `yaml:"method"`
+	SSHUser          string `yaml:"sshuser"`
+	SSHKeyPath       string `yaml:"sshkeypath"`
+	SSHKeyPassphrase string `yaml:"sshkeypassphrase"`
+	KnownHostsFile   string `yaml:"knownhostsfile"`
+}
+
+const authMethodSSH = "ssh"
+
+// defaultTenets is used to generate codelingo.yaml when config.yaml sets no
+// Tenets of its own.
+var defaultTenets = []tenetConfig{
+	{Import: "codelingo/effective-go/comment-first-word-as-subject"},
+}
 
 const configFile = "config.yaml"
 const ignoreData = `vendor/`
@@ -47,11 +77,12 @@ var reviewResultsDir = os.Getenv("HOME") + "/speedlingo-review-results"
 var conf config
 
 func main() {
-	var rf *github.Repository
 	var err error
 	ctx := context.Background()
-	if len(os.Args) != 4 {
+	if len(os.Args) < 3 {
 		fmt.Println("Usage: speedlingo <command> <owner> <repo name>")
+		fmt.Println("       speedlingo batch <batch file>")
+		fmt.Println("       speedlingo daemon <daemon config file>")
 		os.Exit(1)
 	}
 
@@ -64,83 +95,132 @@ func main() {
 		log.Fatal(err)
 	}
 
+	authedClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: conf.Token}))
+	client := github.NewClient(authedClient)
+
+	command := os.Args[1]
+
+	if command == "batch" {
+		if len(os.Args) != 3 {
+			fmt.Println("Usage: speedlingo batch <batch file>")
+			os.Exit(1)
+		}
+		if err := runBatch(ctx, client, os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if command == "daemon" {
+		if len(os.Args) != 3 {
+			fmt.Println("Usage: speedlingo daemon <daemon config file>")
+			os.Exit(1)
+		}
+		if err := runDaemon(ctx, client, os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) != 4 {
+		fmt.Println("Usage: speedlingo <command> <owner> <repo name>")
+		os.Exit(1)
+	}
 	owner := os.Args[2]
 	repo := os.Args[3]
 
-	authedClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: conf.Token}))
-	client := github.NewClient(authedClient)
+	tenetsYAML, err := renderTenetsYAML(conf.Tenets)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger := log.New(os.Stdout, "", 0)
+	if err := processRepo(ctx, client, owner, repo, command, branchName, tenetsYAML, logger); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	rf, _, err = client.Repositories.CreateFork(ctx, owner, repo, nil)
+// processRepo forks owner/repo into the configured account, clones the fork,
+// and runs the requested command (review or rewrite) against it. branch and
+// tenetsYAML let callers (e.g. batch mode) override the rewrite branch name
+// and the codelingo.yaml tenets written into the clone.
+func processRepo(ctx context.Context, client *github.Client, owner, repo, command, branch, tenetsYAML string, logger *log.Logger) error {
+	rf, _, err := client.Repositories.CreateFork(ctx, owner, repo, nil)
 	if err != nil {
 		if !strings.Contains(err.Error(), "job scheduled on GitHub side; try again later") {
-			log.Fatal(err)
+			return errors.Trace(err)
 		}
 	}
 
 	timeout := time.Now().Add(time.Minute * 5)
 	for {
 		if time.Now().After(timeout) {
-			log.Fatal(err)
+			return errors.Trace(err)
 		}
 
 		rf, _, err = client.Repositories.Get(ctx, conf.Username, repo)
 		if err != nil {
-			fmt.Println(err.Error())
+			logger.Println(err.Error())
 			time.Sleep(time.Second * 2)
 			continue
 		}
 		break
 	}
 
-	fmt.Println("Forked")
+	logger.Println("Forked")
 
 	// Tempdir to clone the repository
 	dir, err := ioutil.TempDir("", "")
 	if err != nil {
-		log.Fatal(err)
+		return errors.Trace(err)
 	}
 	defer os.RemoveAll(dir) // clean up
 
-	fmt.Println("Created temp dir")
-	fmt.Println("Attempting to clone", *rf.HTMLURL)
+	auth, err := buildAuth(conf, conf.Token)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	url := cloneURL(conf, rf)
+	logger.Println("Created temp dir")
+	logger.Println("Attempting to clone", url)
 	// Clones the repository into the given dir, just as a normal git clone does
 	r, err := git.PlainClone(dir, false, &git.CloneOptions{
-		URL:      *rf.HTMLURL,
+		URL:      url,
+		Auth:     auth,
 		Progress: os.Stdout,
 	})
 	if err != nil {
-		log.Fatal(err)
+		return errors.Trace(err)
 	}
 
-	fmt.Println("Cloned to", dir)
+	logger.Println("Cloned to", dir)
 
 	var cmd *exec.Cmd
-	switch command := os.Args[1]; command {
+	switch command {
 	case "review":
-		fmt.Println("Results will be stored in", reviewResultsDir)
-		cmd = exec.Command("lingo", "run", "review", "--debug", "--keep-all", "-o", reviewResultsDir+"/"+repo+"-"+"results.json")
-		if err := handleReview(dir, conf.Token, r, cmd); err != nil {
-			log.Fatal(err)
-		}
+		logger.Println("Results will be stored in", reviewResultsDir)
+		resultsPath := reviewResultsDir + "/" + repo + "-" + "results.json"
+		cmd = exec.Command("lingo", "run", "review", "--debug", "--keep-all", "-o", resultsPath)
+		cmd.Dir = dir
+		return handleReview(ctx, client, owner, repo, dir, conf.Token, r, cmd, tenetsYAML, resultsPath, logger)
 	case "rewrite":
 		cmd = exec.Command("lingo", "run", "rewrite", "--debug", "--keep-all")
-		if err := handleRewrite(dir, conf.Token, r, cmd); err != nil {
-			log.Fatal(err)
-		}
+		cmd.Dir = dir
+		_, err := handleRewrite(ctx, client, owner, repo, dir, conf.Token, r, cmd, branch, tenetsYAML, logger)
+		return err
 	default:
-		log.Fatal(errors.New("command not found. Commands available: review, rewrite"))
+		return errors.New("command not found. Commands available: review, rewrite")
 	}
 }
 
-func runCmd(dir string, cmd *exec.Cmd) error {
-	err := os.Chdir(dir)
-	if err != nil {
-		return errors.Trace(err)
-	}
-	fmt.Println("Running lingo command...")
+// runCmd runs cmd, which must already have Dir set to the repo's tempdir so
+// concurrent callers (e.g. batch/daemon workers) don't race on process CWD.
+func runCmd(dir string, cmd *exec.Cmd, logger *log.Logger) error {
+	logger.Println("Running lingo command...")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	err := cmd.Run()
 	if err != nil {
 		os.RemoveAll(dir)
 		return errors.Annotate(err, "cmd.Run() failed:")
@@ -149,15 +229,16 @@ func runCmd(dir string, cmd *exec.Cmd) error {
 	return nil
 }
 
-func handleRewrite(dir, token string, r *git.Repository, cmd *exec.Cmd) error {
-	err := os.Chdir(dir)
-	if err != nil {
-		return errors.Trace(err)
-	}
-
+// handleRewrite runs the rewrite pipeline against the already-cloned repo r
+// and returns the URL of the pull request opened (or already open) for
+// branchName. The push is a force-push of branchName alone, and opening the
+// PR tolerates one already being open from a previous attempt, so retrying
+// this whole function (as batch/daemon do) is safe even after a partial
+// prior success.
+func handleRewrite(ctx context.Context, client *github.Client, owner, repo, dir, token string, r *git.Repository, cmd *exec.Cmd, branchName, tenetsYAML string, logger *log.Logger) (string, error) {
 	worktree, err := r.Worktree()
 	if err != nil {
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 
 	branch := fmt.Sprintf("refs/heads/%s", branchName)
@@ -170,58 +251,58 @@ func handleRewrite(dir, token string, r *git.Repository, cmd *exec.Cmd) error {
 		// got an error  - try to create it
 		err := worktree.Checkout(&git.CheckoutOptions{Create: true, Force: false, Branch: b})
 		if err != nil {
-			return errors.Trace(err)
+			return "", errors.Trace(err)
 		}
 	}
 
-	fmt.Println("Created new branch")
+	logger.Println("Created new branch")
 
 	needsIgnoreFile := false
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 	for _, file := range files {
 		if file.Name() == "vendor" && file.IsDir() {
-			fmt.Println("Found vendor directory")
+			logger.Println("Found vendor directory")
 			needsIgnoreFile = true
 		}
 	}
 
 	filename := filepath.Join(dir, yamlName)
-	err = ioutil.WriteFile(filename, []byte(yamlDataRewrite), 0666)
+	err = ioutil.WriteFile(filename, []byte(tenetsYAML), 0666)
 	if err != nil {
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 
 	if needsIgnoreFile {
 		filename := filepath.Join(dir, ignoreFileName)
 		err = ioutil.WriteFile(filename, []byte(ignoreData), 0644)
 		if err != nil {
-			return errors.Trace(err)
+			return "", errors.Trace(err)
 		}
-		fmt.Printf("Wrote %s file\n", ignoreFileName)
+		logger.Printf("Wrote %s file\n", ignoreFileName)
 	}
 
-	fmt.Printf("Wrote %s file\n", yamlName)
+	logger.Printf("Wrote %s file\n", yamlName)
 
-	if err = runCmd(dir, cmd); err != nil {
-		return errors.Trace(err)
+	if err = runCmd(dir, cmd, logger); err != nil {
+		return "", errors.Trace(err)
 	}
 
 	err = worktree.AddGlob(".")
 	if err != nil {
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 	_, err = worktree.Remove(yamlName)
 	if err != nil {
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 
 	if needsIgnoreFile {
 		_, err = worktree.Remove(ignoreFileName)
 		if err != nil {
-			return errors.Trace(err)
+			return "", errors.Trace(err)
 		}
 	}
 
@@ -235,31 +316,90 @@ func handleRewrite(dir, token string, r *git.Repository, cmd *exec.Cmd) error {
 
 	_, err = r.CommitObject(commit)
 	if err != nil {
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 
-	fmt.Println("Committed")
+	logger.Println("Committed")
+
+	auth, err := buildAuth(conf, token)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
 
+	// Force-push branchName alone: a retried call re-commits on top of
+	// whatever's already on the branch, so a plain push would reject as
+	// non-fast-forward instead of converging.
+	refSpec := gitconfig.RefSpec(fmt.Sprintf("+%s:%s", b, b))
 	opt := git.PushOptions{
 		RemoteName: "origin",
-		Auth: &http.BasicAuth{
-			Username: "emptystring", // yes, this can be anything except an empty string
-			Password: token,
-		},
-		Progress: os.Stdout,
+		RefSpecs:   []gitconfig.RefSpec{refSpec},
+		Auth:       auth,
+		Progress:   os.Stdout,
 	}
 
 	err = r.Push(&opt)
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return "", errors.Trace(err)
+	}
+
+	logger.Println("Pushed")
+
+	prURL, err := openPullRequest(ctx, client, owner, repo, branchName, logger)
 	if err != nil {
-		return errors.Trace(err)
+		return "", errors.Trace(err)
 	}
 
-	fmt.Println("Pushed")
+	return prURL, nil
+}
 
-	return nil
+// openPullRequest opens a pull request from the user's rewrite branch against
+// the upstream repo's default branch, using PRTitle/PRBody from config.yaml
+// when set, falling back to the rewrite commit message, and returns its
+// HTML URL. If a PR from branchName is already open (e.g. a retry after the
+// previous attempt's push succeeded but this call failed later), that PR's
+// URL is returned instead of erroring.
+func openPullRequest(ctx context.Context, client *github.Client, owner, repo, branchName string, logger *log.Logger) (string, error) {
+	upstream, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	title := conf.PRTitle
+	if title == "" {
+		title = commitMessageRewrite
+	}
+	body := conf.PRBody
+	if body == "" {
+		body = commitMessageRewrite
+	}
+
+	head := fmt.Sprintf("%s:%s", conf.Username, branchName)
+	pr, _, err := client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
+		Title: &title,
+		Body:  &body,
+		Head:  &head,
+		Base:  upstream.DefaultBranch,
+	})
+	if err != nil {
+		if !strings.Contains(err.Error(), "A pull request already exists") {
+			return "", errors.Trace(err)
+		}
+
+		existing, _, listErr := client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{Head: head, State: "open"})
+		if listErr != nil || len(existing) == 0 {
+			return "", errors.Trace(err)
+		}
+
+		logger.Println("Pull request already open", *existing[0].HTMLURL)
+		return *existing[0].HTMLURL, nil
+	}
+
+	logger.Println("Opened pull request", *pr.HTMLURL)
+
+	return *pr.HTMLURL, nil
 }
 
-func handleReview(dir, token string, r *git.Repository, cmd *exec.Cmd) error {
+func handleReview(ctx context.Context, client *github.Client, owner, repo, dir, token string, r *git.Repository, cmd *exec.Cmd, tenetsYAML, resultsPath string, logger *log.Logger) error {
 	needsIgnoreFile := false
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
@@ -267,13 +407,13 @@ func handleReview(dir, token string, r *git.Repository, cmd *exec.Cmd) error {
 	}
 	for _, file := range files {
 		if file.Name() == "vendor" && file.IsDir() {
-			fmt.Println("Found vendor directory")
+			logger.Println("Found vendor directory")
 			needsIgnoreFile = true
 		}
 	}
 
 	filename := filepath.Join(dir, yamlName)
-	err = ioutil.WriteFile(filename, []byte(yamlDataRewrite), 0666)
+	err = ioutil.WriteFile(filename, []byte(tenetsYAML), 0666)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -284,17 +424,107 @@ func handleReview(dir, token string, r *git.Repository, cmd *exec.Cmd) error {
 		if err != nil {
 			return errors.Trace(err)
 		}
-		fmt.Printf("Wrote %s file\n", ignoreFileName)
+		logger.Printf("Wrote %s file\n", ignoreFileName)
+	}
+
+	logger.Printf("Wrote %s file\n", yamlName)
+	err = runCmd(dir, cmd, logger)
+	if err != nil {
+		return errors.Trace(err)
 	}
 
-	fmt.Printf("Wrote %s file\n", yamlName)
-	err = runCmd(dir, cmd)
+	issues, err := parseReviewResults(resultsPath)
 	if err != nil {
 		return errors.Trace(err)
 	}
+
+	mdPath := strings.TrimSuffix(resultsPath, ".json") + ".md"
+	markdown := renderReviewMarkdown(issues)
+	if err = ioutil.WriteFile(mdPath, []byte(markdown), 0644); err != nil {
+		return errors.Trace(err)
+	}
+	logger.Println("Wrote Markdown summary to", mdPath)
+
+	sarifPath := strings.TrimSuffix(resultsPath, ".json") + ".sarif"
+	sarifDoc, err := renderReviewSARIF(issues)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = ioutil.WriteFile(sarifPath, sarifDoc, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	logger.Println("Wrote SARIF report to", sarifPath)
+
+	if token != "" {
+		head, err := r.Head()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = postReviewCheckRun(ctx, client, owner, repo, head.Name().Short(), head.Hash().String(), markdown, len(issues)); err != nil {
+			logger.Println("Failed to post check run:", err)
+		}
+	}
+
 	return nil
 }
 
+// buildAuth returns the transport.AuthMethod to use for cloning and pushing,
+// following the Auth.Method choice in config.yaml. It defaults to HTTPS with
+// the configured token when Auth.Method is unset.
+func buildAuth(conf config, token string) (transport.AuthMethod, error) {
+	if conf.Auth.Method != authMethodSSH {
+		return &http.BasicAuth{
+			Username: "emptystring", // yes, this can be anything except an empty string
+			Password: token,
+		}, nil
+	}
+
+	sshUser := conf.Auth.SSHUser
+	if sshUser == "" {
+		sshUser = "git"
+	}
+
+	auth, err := ssh.NewPublicKeysFromFile(sshUser, conf.Auth.SSHKeyPath, conf.Auth.SSHKeyPassphrase)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if conf.Auth.KnownHostsFile != "" {
+		callback, err := ssh.NewKnownHostsCallback(conf.Auth.KnownHostsFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		auth.HostKeyCallback = callback
+	}
+
+	return auth, nil
+}
+
+// cloneURL picks the HTTPS or SSH clone URL for rf depending on the
+// configured auth method.
+func cloneURL(conf config, rf *github.Repository) string {
+	if conf.Auth.Method == authMethodSSH {
+		return *rf.SSHURL
+	}
+	return *rf.HTMLURL
+}
+
+// renderTenetsYAML marshals tenets into a codelingo.yaml document, falling
+// back to defaultTenets when the caller (config.yaml, or a per-repo batch
+// override) sets none.
+func renderTenetsYAML(tenets []tenetConfig) (string, error) {
+	if len(tenets) == 0 {
+		tenets = defaultTenets
+	}
+
+	data, err := yaml.Marshal(tenetsFile{Tenets: tenets})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	return string(data), nil
+}
+
 func unmarshalConfigFile() (config, error) {
 	var result config
 	str, err := ioutil.ReadFile(configFile)
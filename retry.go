@@ -0,0 +1,21 @@
+package main
+
+import "time"
+
+// withRetry calls f until it succeeds or attempts is exhausted, sleeping delay
+// (doubling after each failure) between tries. It returns the error from the
+// last attempt when none succeed.
+func withRetry(attempts int, delay time.Duration, f func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		if i == attempts-1 {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return err
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/juju/errors"
+)
+
+// ReviewIssue is one finding from a `lingo run review` pass.
+type ReviewIssue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Tenet    string `json:"tenet"`
+	Comment  string `json:"comment"`
+	Severity string `json:"severity"`
+}
+
+const sarifSchema = "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json"
+const sarifVersion = "2.1.0"
+const sarifToolName = "codelingo"
+
+// parseReviewResults reads the JSON results file written by `lingo run
+// review -o <path>` and decodes it into a list of ReviewIssue.
+func parseReviewResults(path string) ([]ReviewIssue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var issues []ReviewIssue
+	if err = json.Unmarshal(data, &issues); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return issues, nil
+}
+
+// renderReviewMarkdown renders issues as a Markdown summary grouped by file,
+// then by tenet within each file.
+func renderReviewMarkdown(issues []ReviewIssue) string {
+	if len(issues) == 0 {
+		return "# Review summary\n\nNo issues found.\n"
+	}
+
+	byFile := map[string][]ReviewIssue{}
+	for _, issue := range issues {
+		byFile[issue.File] = append(byFile[issue.File], issue)
+	}
+
+	files := make([]string, 0, len(byFile))
+	for file := range byFile {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Review summary\n\n%d issue(s) across %d file(s)\n\n", len(issues), len(files)))
+
+	for _, file := range files {
+		sb.WriteString(fmt.Sprintf("## %s\n\n", file))
+
+		byTenet := map[string][]ReviewIssue{}
+		for _, issue := range byFile[file] {
+			byTenet[issue.Tenet] = append(byTenet[issue.Tenet], issue)
+		}
+
+		tenets := make([]string, 0, len(byTenet))
+		for tenet := range byTenet {
+			tenets = append(tenets, tenet)
+		}
+		sort.Strings(tenets)
+
+		for _, tenet := range tenets {
+			sb.WriteString(fmt.Sprintf("### %s\n\n", tenet))
+			for _, issue := range byTenet[tenet] {
+				sb.WriteString(fmt.Sprintf("- line %d (%s): %s\n", issue.Line, issue.Severity, issue.Comment))
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// sarif* types are a minimal subset of the SARIF 2.1.0 schema, sized to what
+// renderReviewSARIF needs to emit.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules,omitempty"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// renderReviewSARIF renders issues as a SARIF 2.1.0 document suitable for
+// upload to GitHub Code Scanning.
+func renderReviewSARIF(issues []ReviewIssue) ([]byte, error) {
+	rules := map[string]bool{}
+	results := make([]sarifResult, 0, len(issues))
+	for _, issue := range issues {
+		rules[issue.Tenet] = true
+		results = append(results, sarifResult{
+			RuleID: issue.Tenet,
+			Level:  sarifLevel(issue.Severity),
+			Message: sarifMessage{
+				Text: issue.Comment,
+			},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: issue.File},
+					Region:           sarifRegion{StartLine: issue.Line},
+				},
+			}},
+		})
+	}
+
+	ruleIDs := make([]string, 0, len(rules))
+	for ruleID := range rules {
+		ruleIDs = append(ruleIDs, ruleID)
+	}
+	sort.Strings(ruleIDs)
+
+	sarifRules := make([]sarifRule, len(ruleIDs))
+	for i, ruleID := range ruleIDs {
+		sarifRules[i] = sarifRule{ID: ruleID}
+	}
+
+	doc := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{
+				Driver: sarifDriver{Name: sarifToolName, Rules: sarifRules},
+			},
+			Results: results,
+		}},
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return data, nil
+}
+
+// sarifLevel maps a ReviewIssue's severity onto a SARIF result level.
+func sarifLevel(severity string) string {
+	switch strings.ToLower(severity) {
+	case "error", "critical":
+		return "error"
+	case "note", "info":
+		return "note"
+	default:
+		return "warning"
+	}
+}
+
+// postReviewCheckRun posts the Markdown summary as a completed check run
+// against headSHA on the upstream repo.
+func postReviewCheckRun(ctx context.Context, client *github.Client, owner, repo, headBranch, headSHA, markdown string, issueCount int) error {
+	conclusion := "success"
+	if issueCount > 0 {
+		conclusion = "neutral"
+	}
+
+	_, _, err := client.Checks.CreateCheckRun(ctx, owner, repo, github.CreateCheckRunOptions{
+		Name:       "speedlingo-review",
+		HeadBranch: headBranch,
+		HeadSHA:    headSHA,
+		Status:     github.String("completed"),
+		Conclusion: github.String(conclusion),
+		Output: &github.CheckRunOutput{
+			Title:   github.String("speedlingo review"),
+			Summary: github.String(markdown),
+		},
+	})
+
+	return errors.Trace(err)
+}